@@ -11,40 +11,94 @@ It is intended to be run via the go generate tool and creates an instance
 variable that provides file-like access to the embedded assets using
 a bytes.Reader.
 
-Additionally each file instance complies with the http.File interface.
-
-By default the generated package does not provide a type compatible with the
-http.FileServer interface to avoid importing net/http - Supplying the
--include-http flag will enable support for that interface.
+The generated instance satisfies io/fs.FS, fs.ReadDirFS, fs.StatFS, fs.GlobFS
+and fs.SubFS, including synthetic directory entries, so it's a drop-in for
+http.FS, template.ParseFS and other stdlib consumers.
 
 For example to embed the html and css files in an assets directory into
 a new file called assets.go as part of a package called webserver:
 
-  embedfiles -filename assets.go -package webserver -include-http -var Assets assets/*.html assets/*.css
+  embedfiles -filename assets.go -package webserver -var Assets assets/*.html assets/*.css
 
 Code within the assets package could then open index.html for read:
 
   f, err := Assets.Open("assets/index.html")
 
-or could use an instance as a file server (assuming the -include-http flag was set):
+or serve the whole tree, directory listings included, over HTTP:
 
-  log.Fatal(http.ListenAndServe(":8080", http.FileServer(Assets))
+  log.Fatal(http.ListenAndServe(":8080", http.FileServer(http.FS(Assets))))
 
 As each call to embedfiles generates a completely self-contained .go file,
 multiple independent .go files can be generated and compiled into a single
 package by using different -varname options, allowing for discrete groups
 of files to be assigned to different variable names.
 
+The -compress flag shrinks the generated binary by storing compressed file
+contents and decompressing them on demand.  "auto" skips files that are
+unlikely to compress well (e.g. already-compressed images) or that don't
+shrink meaningfully, while "gzip" and "brotli" always compress.  Decompression
+happens transparently the first time a file is opened.  When -include-http is
+also set, OpenEncoded lets an HTTP handler ship the stored bytes directly to
+clients that advertise support for the same encoding via Accept-Encoding,
+avoiding a re-compress on every request.
+
+The -recursive flag descends into any directory matched on the command line
+instead of requiring every file to be named by a glob.  -root makes embedded
+paths relative to a given directory rather than as written on the command
+line, -strip-prefix removes an additional prefix from the stored path, and
+-include/-exclude (repeatable) filter the walked files using gitignore-style
+patterns: a pattern with no slash matches against any path component, a
+pattern with a slash matches the full relative path, and "**" matches zero or
+more path components.
+
+Setting -tag splits the output in two: -filename itself is written gated
+behind "//go:build <tag>" and a sibling "<name>_dummy.go" is written gated
+behind "!<tag>", exposing the same API but reading from the directory named
+by -dummy via os.DirFS at runtime.  Build normally for fast dev iteration
+against files on disk; build with -tags <tag> to get the sealed, fully
+embedded binary.
+
+Files are deduplicated by content within a single run: if two input files
+hash to the same SHA-256, the later one reuses the earlier one's stored
+bytes instead of being appended again.  That hash is also exposed at
+runtime via the generated FileInfo's Sum method, so callers can build an
+ETag without re-hashing.  -manifest records each input's path, hash, size
+and mtime to a JSON file alongside a fingerprint of the flags that shape the
+output (-package, -var, -compress, -tag, -include-http, -goimports); on the
+next run, if every input and that fingerprint still match what's in the
+manifest, generation is skipped entirely, making embedfiles suitable as an
+incremental step over large asset trees.
+
   Usage:  embedfiles [arguments] <file glob> [<file glob> ...]
 
   Arguments:
 
+    -compress string
+          Compress embedded file contents: none, gzip, brotli or auto (default "none")
+    -exclude value
+          Skip files matching this gitignore-style pattern (repeatable)
     -filename string
           File to write go output to.  Defaults to stdout (default "-")
+    -goimports
+          Run goimports instead of gofmt over the generated output, fixing up the import block
+    -include value
+          Only embed files matching this gitignore-style pattern (repeatable)
     -include-http
-          If true then the generated file will import net/http and comply with the http.FileSystem interface
+          If true then the generated file will also provide OpenEncoded, a fast path for HTTP handlers that negotiate Content-Encoding themselves
+    -manifest string
+          Path to a JSON manifest of {path, sha256, size, mtime} recording the last run; when unchanged, generation is skipped
     -package string
           Package name to use for output file. (default "main")
+    -recursive
+          If true, descend into directories matched by a glob and embed every file found
+    -root string
+          Store embedded paths relative to this directory instead of as given on the command line
+    -strip-prefix string
+          Additional prefix to remove from each stored path, applied after -root
+    -tag string
+          Build tag to gate the embedded output behind; also writes a <name>_dummy.go that reads from -dummy on disk when the tag isn't set
+    -dummy string
+          On-disk directory the <name>_dummy.go stub serves from at runtime; required when -tag is set
     -var string
           Variable name to assign the assets to.  Start with a capital letter to export from the package (default "assets")
 
@@ -53,100 +107,482 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"go/format"
+	"hash/crc32"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"text/template"
-	"time"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/tools/imports"
 )
 
+// autoCompressRatio is the maximum compressed/uncompressed size ratio for
+// -compress auto to bother storing the compressed form of a file.
+const autoCompressRatio = 0.9
+
 var (
-	fn      = flag.String("filename", "-", "File to write go output to.  Defaults to stdout")
-	pkg     = flag.String("package", "main", "Package name to use for output file.")
-	prefix  = flag.String("var", "assets", "Variable name to assign the assets to.  Start with a capital letter to export from the package")
-	incHTTP = flag.Bool("include-http", false, "If true then the generated file will import net/http and comply with the http.FileSystem interface")
+	fn          = flag.String("filename", "-", "File to write go output to.  Defaults to stdout")
+	pkg         = flag.String("package", "main", "Package name to use for output file.")
+	prefix      = flag.String("var", "assets", "Variable name to assign the assets to.  Start with a capital letter to export from the package")
+	incHTTP     = flag.Bool("include-http", false, "If true then the generated file will also provide OpenEncoded, a fast path for HTTP handlers that negotiate Content-Encoding themselves")
+	compress    = flag.String("compress", "none", "Compress embedded file contents: none, gzip, brotli or auto")
+	recursive   = flag.Bool("recursive", false, "If true, descend into directories matched by a glob and embed every file found")
+	root        = flag.String("root", "", "Store embedded paths relative to this directory instead of as given on the command line")
+	stripPrefix = flag.String("strip-prefix", "", "Additional prefix to remove from each stored path, applied after -root")
+	tag         = flag.String("tag", "", "Build tag to gate the embedded output behind; also writes a <name>_dummy.go that reads from -dummy on disk when the tag isn't set")
+	dummyPath   = flag.String("dummy", "", "On-disk directory the <name>_dummy.go stub serves from at runtime; required when -tag is set")
+	goimportsFl = flag.Bool("goimports", false, "Run goimports instead of gofmt over the generated output, fixing up the import block")
+	manifestFl  = flag.String("manifest", "", "Path to a JSON manifest of {path, sha256, size, mtime} recording the last run; when unchanged, generation is skipped")
+	includes    patternList
+	excludes    patternList
 )
 
+func init() {
+	flag.Var(&includes, "include", "Only embed files matching this gitignore-style pattern (repeatable)")
+	flag.Var(&excludes, "exclude", "Skip files matching this gitignore-style pattern (repeatable)")
+}
+
+// patternList collects repeated occurrences of a flag into a slice.
+type patternList []string
+
+func (p *patternList) String() string { return strings.Join(*p, ",") }
+func (p *patternList) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
+// compressibleExts lists file extensions worth compressing under -compress auto.
+// Binary formats that are already compressed (images, fonts, archives) are
+// deliberately excluded since compressing them again rarely pays off.
+var compressibleExts = map[string]bool{
+	".html": true,
+	".htm":  true,
+	".css":  true,
+	".js":   true,
+	".mjs":  true,
+	".json": true,
+	".svg":  true,
+	".xml":  true,
+	".txt":  true,
+	".md":   true,
+	".csv":  true,
+	".yaml": true,
+	".yml":  true,
+}
+
 var header = `package {{ .Pkg }}
 
 // Generated by github.com/gwatts/embedfiles
-// at {{ .Time }}
 
 import (
 	"bytes"
+	{{ if .UsesGzip -}}
+	"compress/gzip"
+	{{- end }}
 	"errors"
-	{{ if .IncludeHTTP -}}
-	"net/http"
+	{{ if .UsesGzip -}}
+	"hash/crc32"
 	{{- end }}
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"sort"
+	{{ if .IncludeHTTP -}}
 	"strings"
+	{{- end }}
 	"time"
+	{{ if .UsesBrotli -}}
+
+	"github.com/andybalholm/brotli"
+	{{- end }}
 )
 
 type {{ .Prefix }}File struct {
-	*bytes.Reader
-	fi {{ .Prefix }}FI
+	r        *bytes.Reader
+	fi       {{ .Prefix }}FI
+	children []{{ .Prefix }}FI
+	pos      int
+}
+
+func (f *{{ .Prefix }}File) Read(p []byte) (int, error) {
+	if f.fi.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.fi.name, Err: fs.ErrInvalid}
+	}
+	return f.r.Read(p)
+}
+
+func (f *{{ .Prefix }}File) Seek(offset int64, whence int) (int64, error) {
+	if f.fi.IsDir() {
+		return 0, &fs.PathError{Op: "seek", Path: f.fi.name, Err: fs.ErrInvalid}
+	}
+	return f.r.Seek(offset, whence)
 }
 
-func (f *{{ .Prefix}}File) Close() error { return nil }
-func (f *{{ .Prefix}}File) Readdir(count int) ([]os.FileInfo, error) { return nil, errors.New("Denied")}
+func (f *{{ .Prefix }}File) Close() error { return nil }
 func (f *{{ .Prefix }}File) Stat() (os.FileInfo, error) { return f.fi, nil }
 
+func (f *{{ .Prefix }}File) readdir(n int) ([]{{ .Prefix }}FI, error) {
+	if !f.fi.IsDir() {
+		return nil, errors.New("{{ .Prefix }}: not a directory")
+	}
+	remaining := f.children[f.pos:]
+	if n <= 0 {
+		f.pos = len(f.children)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	f.pos += n
+	return remaining[:n], nil
+}
+
+// Readdir implements http.File's directory listing.
+func (f *{{ .Prefix }}File) Readdir(n int) ([]os.FileInfo, error) {
+	children, err := f.readdir(n)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]os.FileInfo, len(children))
+	for i, c := range children {
+		out[i] = c
+	}
+	return out, nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (f *{{ .Prefix }}File) ReadDir(n int) ([]fs.DirEntry, error) {
+	children, err := f.readdir(n)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]fs.DirEntry, len(children))
+	for i, c := range children {
+		out[i] = c
+	}
+	return out, nil
+}
+
 type {{ .Prefix }}FI struct {
 	name  string
 	size  int64
 	mode  os.FileMode
 	ftime time.Time
+	sum   [32]byte
 }
 
-func (fi {{ .Prefix }}FI) Name() string       { return fi.name }
-func (fi {{ .Prefix }}FI) Close() error       { return nil }
-func (fi {{ .Prefix }}FI) Size() int64        { return fi.size }
-func (fi {{ .Prefix }}FI) Mode() os.FileMode  { return fi.mode }
-func (fi {{ .Prefix }}FI) ModTime() time.Time { return fi.ftime }
-func (fi {{ .Prefix }}FI) IsDir() bool        { return false }
-func (fi {{ .Prefix }}FI) Sys() interface{}   { return nil }
+func (fi {{ .Prefix }}FI) Name() string              { return fi.name }
+func (fi {{ .Prefix }}FI) Size() int64               { return fi.size }
+func (fi {{ .Prefix }}FI) Mode() os.FileMode         { return fi.mode }
+func (fi {{ .Prefix }}FI) ModTime() time.Time        { return fi.ftime }
+func (fi {{ .Prefix }}FI) IsDir() bool               { return fi.mode&os.ModeDir != 0 }
+func (fi {{ .Prefix }}FI) Sys() interface{}          { return nil }
+func (fi {{ .Prefix }}FI) Type() os.FileMode         { return fi.mode.Type() }
+func (fi {{ .Prefix }}FI) Info() (os.FileInfo, error) { return fi, nil }
+
+// Sum returns the SHA-256 of the file's original (uncompressed) contents,
+// letting callers build an ETag without re-hashing at runtime.  It is the
+// zero value for directories.
+func (fi {{ .Prefix }}FI) Sum() [32]byte { return fi.sum }
 
 type {{ .Prefix }}T struct {
 	filenames []string
 	files     map[string]struct {
-		ts int64
+		ts     int64
 		offset int
 		size   int
+		usize  int
+		algo   string
+		crc    uint32
+		sum    [32]byte
 	}
+	dirs map[string][]{{ .Prefix }}FI
 	data [{{ .DataSize }}]byte
 }
 
-func (fs *{{ .Prefix }}T) Filenames() []string { return fs.filenames }
+func (t *{{ .Prefix }}T) Filenames() []string { return t.filenames }
+
+// decompress returns the original contents of a file given its stored
+// (possibly compressed) bytes, algorithm and original size.
+func (t *{{ .Prefix }}T) decompress(algo string, raw []byte, usize int, crc uint32) ([]byte, error) {
+	switch algo {
+	case "none":
+		return raw, nil
+	{{ if .UsesGzip -}}
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		b := make([]byte, usize)
+		if _, err := io.ReadFull(zr, b); err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(b) != crc {
+			return nil, errors.New("{{ .Prefix }}: gzip crc32 mismatch")
+		}
+		return b, nil
+	{{ end -}}
+	{{ if .UsesBrotli -}}
+	case "brotli":
+		b := make([]byte, usize)
+		if _, err := io.ReadFull(brotli.NewReader(bytes.NewReader(raw)), b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	{{ end -}}
+	default:
+		return nil, errors.New("{{ .Prefix }}: unknown compression algorithm")
+	}
+}
+
+// {{ .Prefix }}checkPath reports whether name is a valid fs.FS path for the
+// given operation, returning an *fs.PathError if not. fs.FS implementations
+// must reject absolute paths, ".." elements and the like rather than
+// silently normalizing them; see fs.ValidPath.
+func {{ .Prefix }}checkPath(op, name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
 
-// Open returns a bytes.Reader for the given filename.
-{{ if .IncludeHTTP -}}
-func (fs *{{ .Prefix }}T) Open(filename string) (http.File, error) {
-{{- else -}}
-func (fs *{{ .Prefix }}T) Open(filename string) (*{{ .Prefix }}File, error) {
-{{- end }}
-	filename = strings.TrimPrefix(filename, "/")
-	entry, ok := fs.files[filename]
+// Open opens the named file or directory, implementing fs.FS.
+func (t *{{ .Prefix }}T) Open(name string) (fs.File, error) {
+	if err := {{ .Prefix }}checkPath("open", name); err != nil {
+		return nil, err
+	}
+	if children, ok := t.dirs[name]; ok {
+		return &{{ .Prefix }}File{
+			fi:       {{ .Prefix }}FI{name: path.Base(name), mode: os.ModeDir | os.ModePerm},
+			children: children,
+		}, nil
+	}
+	entry, ok := t.files[name]
 	if !ok {
-		return nil, os.ErrNotExist
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	raw := t.data[entry.offset : entry.offset+entry.size]
+	b, err := t.decompress(entry.algo, raw, entry.usize, entry.crc)
+	if err != nil {
+		return nil, err
 	}
-	b := fs.data[entry.offset : entry.offset+entry.size]
 	return &{{ .Prefix }}File{
-		Reader: bytes.NewReader(b),
-		fi:     {{ .Prefix }}FI{name: path.Base(filename), size: int64(entry.size), mode: os.ModePerm, ftime: time.Unix(entry.ts, 0)},
+		r:  bytes.NewReader(b),
+		fi: {{ .Prefix }}FI{name: path.Base(name), size: int64(entry.usize), mode: os.ModePerm, ftime: time.Unix(entry.ts, 0), sum: entry.sum},
 	}, nil
 }
 
+// Stat implements fs.StatFS.
+func (t *{{ .Prefix }}T) Stat(name string) (os.FileInfo, error) {
+	if err := {{ .Prefix }}checkPath("stat", name); err != nil {
+		return nil, err
+	}
+	if _, ok := t.dirs[name]; ok {
+		return {{ .Prefix }}FI{name: path.Base(name), mode: os.ModeDir | os.ModePerm}, nil
+	}
+	entry, ok := t.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return {{ .Prefix }}FI{name: path.Base(name), size: int64(entry.usize), mode: os.ModePerm, ftime: time.Unix(entry.ts, 0), sum: entry.sum}, nil
+}
+
+// ReadDir implements fs.ReadDirFS.
+func (t *{{ .Prefix }}T) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := {{ .Prefix }}checkPath("readdir", name); err != nil {
+		return nil, err
+	}
+	children, ok := t.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	out := make([]fs.DirEntry, len(children))
+	for i, c := range children {
+		out[i] = c
+	}
+	return out, nil
+}
+
+// Glob implements fs.GlobFS.
+func (t *{{ .Prefix }}T) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	var names []string
+	for n := range t.files {
+		if ok, _ := path.Match(pattern, n); ok {
+			names = append(names, n)
+		}
+	}
+	for n := range t.dirs {
+		if n == "." {
+			continue
+		}
+		if ok, _ := path.Match(pattern, n); ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Sub implements fs.SubFS.
+func (t *{{ .Prefix }}T) Sub(dir string) (fs.FS, error) {
+	if err := {{ .Prefix }}checkPath("sub", dir); err != nil {
+		return nil, err
+	}
+	if dir == "." {
+		return t, nil
+	}
+	if _, ok := t.dirs[dir]; !ok {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: fs.ErrNotExist}
+	}
+	return &{{ .Prefix }}SubFS{fsys: t, dir: dir}, nil
+}
+
+type {{ .Prefix }}SubFS struct {
+	fsys *{{ .Prefix }}T
+	dir  string
+}
+
+func (s *{{ .Prefix }}SubFS) full(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return s.dir, nil
+	}
+	return s.dir + "/" + name, nil
+}
+
+func (s *{{ .Prefix }}SubFS) Open(name string) (fs.File, error) {
+	full, err := s.full(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.fsys.Open(full)
+}
+
+func (s *{{ .Prefix }}SubFS) Stat(name string) (os.FileInfo, error) {
+	full, err := s.full(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.fsys.Stat(full)
+}
+
+func (s *{{ .Prefix }}SubFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := s.full(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.fsys.ReadDir(full)
+}
+{{ if .IncludeHTTP }}
+// OpenEncoded returns the bytes for filename exactly as they're stored
+// (which may be compressed) along with the Content-Encoding value a caller
+// should set on the response, chosen based on acceptEncoding (the value of
+// an incoming Accept-Encoding header). If the stored encoding isn't
+// acceptable to the caller, the file is decompressed and "" is returned as
+// the encoding.
+func (t *{{ .Prefix }}T) OpenEncoded(filename, acceptEncoding string) ([]byte, string, error) {
+	if err := {{ .Prefix }}checkPath("open", filename); err != nil {
+		return nil, "", err
+	}
+	entry, ok := t.files[filename]
+	if !ok {
+		return nil, "", &fs.PathError{Op: "open", Path: filename, Err: fs.ErrNotExist}
+	}
+	raw := t.data[entry.offset : entry.offset+entry.size]
+	switch entry.algo {
+	case "gzip":
+		if strings.Contains(acceptEncoding, "gzip") {
+			return raw, "gzip", nil
+		}
+	case "brotli":
+		if strings.Contains(acceptEncoding, "br") {
+			return raw, "br", nil
+		}
+	}
+	b, err := t.decompress(entry.algo, raw, entry.usize, entry.crc)
+	return b, "", err
+}
+{{ end }}
 `
 
 var headerTmpl = template.Must(template.New("header").Parse(header))
 
+// dummyHeader generates a stub that implements the same fs.FS-based API as
+// the real output but reads files from disk at runtime, for fast dev
+// iteration under "go build" without the {{ .Tag }} build tag.
+var dummyHeader = `//go:build !{{ .Tag }}
+// +build !{{ .Tag }}
+
+package {{ .Pkg }}
+
+// Generated by github.com/gwatts/embedfiles (dummy/dev mode)
+//
+// This file is built when the "{{ .Tag }}" build tag is NOT set, and serves
+// {{ .Prefix }} directly from {{ .DummyPathQ }} on disk so changes are picked
+// up without regenerating this file.  Build with -tags {{ .Tag }} to switch
+// to the fully embedded, sealed version.
+
+import (
+	"io/fs"
+	"os"
+	"sort"
+)
+
+type {{ .Prefix }}T struct {
+	fsys fs.FS
+}
+
+func (t *{{ .Prefix }}T) Filenames() []string {
+	var names []string
+	fs.WalkDir(t.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			names = append(names, p)
+		}
+		return err
+	})
+	sort.Strings(names)
+	return names
+}
+
+func (t *{{ .Prefix }}T) Open(name string) (fs.File, error)       { return t.fsys.Open(name) }
+func (t *{{ .Prefix }}T) Stat(name string) (fs.FileInfo, error)   { return fs.Stat(t.fsys, name) }
+func (t *{{ .Prefix }}T) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(t.fsys, name) }
+func (t *{{ .Prefix }}T) Glob(pattern string) ([]string, error)   { return fs.Glob(t.fsys, pattern) }
+func (t *{{ .Prefix }}T) Sub(dir string) (fs.FS, error)           { return fs.Sub(t.fsys, dir) }
+{{ if .IncludeHTTP }}
+func (t *{{ .Prefix }}T) OpenEncoded(filename, acceptEncoding string) ([]byte, string, error) {
+	b, err := fs.ReadFile(t.fsys, filename)
+	return b, "", err
+}
+{{ end }}
+var {{ .Prefix }} = &{{ .Prefix }}T{fsys: os.DirFS({{ .DummyPathQ }})}
+`
+
+var dummyTmpl = template.Must(template.New("dummy").Parse(dummyHeader))
+
 func init() {
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "embedfiles reads one or more files and embeds them into a .go source file")
@@ -158,7 +594,6 @@ func init() {
 		fmt.Fprintln(os.Stderr)
 		flag.PrintDefaults()
 	}
-	flag.Parse()
 }
 
 func fail(format string, a ...interface{}) {
@@ -196,95 +631,675 @@ func fmtBytes(out io.Writer, in io.Reader) (bytesRead, bytesWritten int, err err
 	}
 }
 
+// isCompressibleName reports whether name's extension is a format that
+// typically benefits from compression; used by -compress auto to avoid
+// wasting time on assets such as images that are already compressed.
+func isCompressibleName(name string) bool {
+	return compressibleExts[strings.ToLower(filepath.Ext(name))]
+}
+
+func gzipCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func brotliCompress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	if _, err := bw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressFile compresses data according to mode and returns the bytes to
+// store, the algorithm actually used ("none", "gzip" or "brotli") and, for
+// gzip, a CRC32 of the original data so decompression can be verified.
+func compressFile(mode, name string, data []byte) (out []byte, algo string, crc uint32, err error) {
+	switch mode {
+	case "none":
+		return data, "none", 0, nil
+	case "gzip":
+		out, err = gzipCompress(data)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		return out, "gzip", crc32.ChecksumIEEE(data), nil
+	case "brotli":
+		out, err = brotliCompress(data)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		return out, "brotli", 0, nil
+	case "auto":
+		if !isCompressibleName(name) {
+			return data, "none", 0, nil
+		}
+		gz, err := gzipCompress(data)
+		if err != nil {
+			return nil, "", 0, err
+		}
+		if len(data) == 0 || float64(len(gz)) > float64(len(data))*autoCompressRatio {
+			return data, "none", 0, nil
+		}
+		return gz, "gzip", crc32.ChecksumIEEE(data), nil
+	default:
+		return nil, "", 0, fmt.Errorf("unknown -compress mode %q", mode)
+	}
+}
+
 type entry struct {
 	ts     int64
 	offset int
 	size   int
+	usize  int
+	algo   string
+	crc    uint32
+	sum    [32]byte
 }
 
-func generate(w io.Writer, pkg, prefix string, globs []string) error {
-	var offset, fcount int
-	var filenames []string
-	filemap := make(map[string]entry)
-	databuf := new(bytes.Buffer)
+// inputFile is a single file selected by walkInputs: path is where to read
+// its contents from on disk, and name is the (slash-separated) path to
+// store it under in the generated output.
+type inputFile struct {
+	path string
+	name string
+}
 
-	for _, pattern := range globs {
-		names, err := filepath.Glob(pattern)
+type walkOpts struct {
+	recursive   bool
+	root        string
+	stripPrefix string
+	includes    []string
+	excludes    []string
+}
+
+// matchGlobPattern reports whether relPath (always slash-separated) matches
+// pattern, using gitignore-style semantics: a pattern with no slash matches
+// against any path component, a pattern with a slash matches the full path,
+// and "**" within a pattern matches zero or more path components.
+func matchGlobPattern(pattern, relPath string) (bool, error) {
+	pattern = filepath.ToSlash(pattern)
+	if strings.Contains(pattern, "**") {
+		return matchDoubleStar(strings.Split(pattern, "/"), strings.Split(relPath, "/"))
+	}
+	if strings.Contains(pattern, "/") {
+		return path.Match(pattern, relPath)
+	}
+	for _, part := range strings.Split(relPath, "/") {
+		if ok, err := path.Match(pattern, part); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func matchDoubleStar(pat, name []string) (bool, error) {
+	if len(pat) == 0 {
+		return len(name) == 0, nil
+	}
+	if pat[0] == "**" {
+		if len(pat) == 1 {
+			return true, nil
+		}
+		for i := 0; i <= len(name); i++ {
+			if ok, err := matchDoubleStar(pat[1:], name[i:]); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	if len(name) == 0 {
+		return false, nil
+	}
+	if ok, err := path.Match(pat[0], name[0]); err != nil || !ok {
+		return false, err
+	}
+	return matchDoubleStar(pat[1:], name[1:])
+}
+
+func matchAny(patterns []string, relPath string) (bool, error) {
+	for _, p := range patterns {
+		if ok, err := matchGlobPattern(p, relPath); err != nil {
+			return false, err
+		} else if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// relativeName computes the name a file is stored under: its path relative
+// to root (if set), slash-separated, with stripPrefix removed.
+func relativeName(srcPath, root, stripPrefix string) (string, error) {
+	name := srcPath
+	if root != "" {
+		rel, err := filepath.Rel(root, srcPath)
+		if err != nil {
+			return "", err
+		}
+		name = rel
+	}
+	name = strings.TrimPrefix(filepath.ToSlash(name), "/")
+	if stripPrefix != "" {
+		name = strings.TrimPrefix(name, strings.TrimPrefix(filepath.ToSlash(stripPrefix), "/"))
+		name = strings.TrimPrefix(name, "/")
+	}
+	return name, nil
+}
+
+// walkDir recursively visits the regular files under root, calling add for
+// each one. It follows symlinked directories but tracks their resolved
+// targets to error out on cycles instead of looping forever.
+func walkDir(root string, add func(path string) error) error {
+	visited := make(map[string]bool)
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if visited[real] {
+			return fmt.Errorf("symlink cycle detected at %s", dir)
+		}
+		visited[real] = true
+
+		entries, err := os.ReadDir(dir)
 		if err != nil {
 			return err
 		}
+		for _, e := range entries {
+			full := filepath.Join(dir, e.Name())
+			isDir := e.IsDir()
+			if e.Type()&os.ModeSymlink != 0 {
+				target, err := filepath.EvalSymlinks(full)
+				if err != nil {
+					return err
+				}
+				tfi, err := os.Stat(target)
+				if err != nil {
+					return err
+				}
+				isDir = tfi.IsDir()
+			}
+			if isDir {
+				if err := walk(full); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := add(full); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+// walkInputs expands globs into the list of files to embed, honoring
+// opts.recursive (descend into matched directories), opts.root and
+// opts.stripPrefix (control the name each file is stored under) and
+// opts.includes/opts.excludes (gitignore-style filters). Results are
+// deduplicated by their stored name and returned sorted by name.
+func walkInputs(globs []string, opts walkOpts) ([]inputFile, error) {
+	seen := make(map[string]bool)
+	var out []inputFile
 
-		for _, name := range names {
-			var ts int64
-			fcount++
-			fmt.Fprintln(databuf, "\n    //", name)
-			f, err := os.Open(name)
+	add := func(srcPath string) error {
+		name, err := relativeName(srcPath, opts.root, opts.stripPrefix)
+		if err != nil {
+			return err
+		}
+		if len(opts.includes) > 0 {
+			ok, err := matchAny(opts.includes, name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+		}
+		if len(opts.excludes) > 0 {
+			ok, err := matchAny(opts.excludes, name)
 			if err != nil {
-				return fmt.Errorf("Failed to read %s: %v", name, err)
+				return err
 			}
-			name = strings.TrimPrefix(name, "/")
-			filenames = append(filenames, name)
-			if fi, err := f.Stat(); err == nil {
-				ts = fi.ModTime().Unix()
+			if ok {
+				return nil
 			}
-			br, _, err := fmtBytes(databuf, f)
-			f.Close()
+		}
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+		out = append(out, inputFile{path: srcPath, name: name})
+		return nil
+	}
+
+	for _, pattern := range globs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			fi, err := os.Lstat(m)
 			if err != nil {
-				return fmt.Errorf("Failed to process %s: %v", name, err)
+				return nil, err
+			}
+			if fi.IsDir() {
+				if !opts.recursive {
+					continue
+				}
+				if err := walkDir(m, add); err != nil {
+					return nil, err
+				}
+				continue
 			}
-			filemap[name] = entry{ts: ts, offset: offset, size: br}
-			offset += br
+			if err := add(m); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out, nil
+}
+
+// dirChild is a synthetic directory entry generated by walking the sorted
+// filenames list; full is only meaningful when isDir is false, and is used
+// to look up the backing file's entry for its size and timestamp.
+type dirChild struct {
+	name  string
+	full  string
+	isDir bool
+}
+
+// buildDirs walks the sorted filenames list and returns, for every
+// directory implied by those paths (including the root, "."), the set of
+// its immediate children.
+func buildDirs(filenames []string) map[string][]dirChild {
+	childSets := make(map[string]map[string]dirChild)
+	ensure := func(dir string) map[string]dirChild {
+		m, ok := childSets[dir]
+		if !ok {
+			m = make(map[string]dirChild)
+			childSets[dir] = m
+		}
+		return m
+	}
+	ensure(".")
+	for _, name := range filenames {
+		dir := path.Dir(name)
+		base := path.Base(name)
+		ensure(dir)[base] = dirChild{name: base, full: name}
+		for dir != "." {
+			parent := path.Dir(dir)
+			db := path.Base(dir)
+			ensure(parent)[db] = dirChild{name: db, isDir: true}
+			dir = parent
+		}
+	}
+	dirs := make(map[string][]dirChild, len(childSets))
+	for dir, set := range childSets {
+		list := make([]dirChild, 0, len(set))
+		for _, c := range set {
+			list = append(list, c)
 		}
+		sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+		dirs[dir] = list
+	}
+	return dirs
+}
+
+func generate(openDst func() (io.Writer, error), pkg, prefix, compress, tag string, globs []string, walkOpts walkOpts, useGoimports bool, manifestPath string) error {
+	var offset int
+	var usesGzip, usesBrotli bool
+	var filenames []string
+	filemap := make(map[string]entry)
+	bySum := make(map[[32]byte]entry)
+	databuf := new(bytes.Buffer)
+	w := new(bytes.Buffer)
+
+	inputs, err := walkInputs(globs, walkOpts)
+	if err != nil {
+		return err
 	}
-	if fcount == 0 {
+	if len(inputs) == 0 {
 		return errors.New("No files found")
 	}
 
+	params := genParams{Pkg: pkg, Prefix: prefix, Compress: compress, Tag: tag, IncludeHTTP: *incHTTP, Goimports: useGoimports}.fingerprint()
+
+	var oldManifest map[string]manifestEntry
+	var oldParams string
+	if manifestPath != "" {
+		if oldParams, oldManifest, err = loadManifest(manifestPath); err != nil {
+			return fmt.Errorf("Failed to read manifest: %v", err)
+		}
+	}
+	newManifest := make(map[string]manifestEntry, len(inputs))
+	upToDate := manifestPath != "" && oldManifest != nil && oldParams == params && len(oldManifest) == len(inputs)
+
+	for _, in := range inputs {
+		var ts int64
+		data, err := os.ReadFile(in.path)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s: %v", in.path, err)
+		}
+		if fi, err := os.Stat(in.path); err == nil {
+			ts = fi.ModTime().Unix()
+		}
+		name := in.name
+		filenames = append(filenames, name)
+
+		sum := sha256.Sum256(data)
+		if manifestPath != "" {
+			me := manifestEntry{Path: name, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(data)), ModTime: ts}
+			newManifest[name] = me
+			if upToDate {
+				if old, ok := oldManifest[name]; !ok || old != me {
+					upToDate = false
+				}
+			}
+		}
+
+		if e, ok := bySum[sum]; ok {
+			// Identical content already appended under another name: point
+			// this name at the existing bytes instead of storing them again.
+			e.ts = ts
+			filemap[name] = e
+			continue
+		}
+
+		cdata, algo, crc, err := compressFile(compress, name, data)
+		if err != nil {
+			return fmt.Errorf("Failed to compress %s: %v", name, err)
+		}
+		switch algo {
+		case "gzip":
+			usesGzip = true
+		case "brotli":
+			usesBrotli = true
+		}
+
+		fmt.Fprintln(databuf, "\n    //", name)
+		br, _, err := fmtBytes(databuf, bytes.NewReader(cdata))
+		if err != nil {
+			return fmt.Errorf("Failed to process %s: %v", name, err)
+		}
+		e := entry{ts: ts, offset: offset, size: br, usize: len(data), algo: algo, crc: crc, sum: sum}
+		filemap[name] = e
+		bySum[sum] = e
+		offset += br
+	}
+
+	if upToDate {
+		return errUpToDate
+	}
+
 	sort.Strings(filenames)
+	dirs := buildDirs(filenames)
+
+	if tag != "" {
+		fmt.Fprintf(w, "//go:build %s\n// +build %s\n\n", tag, tag)
+	}
+
 	headerTmpl.Execute(w, map[string]interface{}{
 		"DataSize":    offset,
 		"Filenames":   fmt.Sprintf("%#v", filenames),
 		"IncludeHTTP": *incHTTP,
 		"Pkg":         pkg,
 		"Prefix":      prefix,
-		"Time":        time.Now().Format(time.UnixDate),
+		"UsesGzip":    usesGzip,
+		"UsesBrotli":  usesBrotli,
 	})
 
 	fmt.Fprintf(w, "var %s = &%sT{\n", prefix, prefix)
 	fmt.Fprintf(w, "    filenames: %#v,\n", filenames)
-	fmt.Fprintf(w, "    files: map[string]struct{ts int64; offset int; size int}{\n")
-	for fn, entry := range filemap {
-		fmt.Fprintf(w, "        %#v: {%d, %d, %d},\n", fn, entry.ts, entry.offset, entry.size)
+	fmt.Fprintf(w, "    files: map[string]struct{ts int64; offset int; size int; usize int; algo string; crc uint32; sum [32]byte}{\n")
+	for _, name := range filenames {
+		e := filemap[name]
+		fmt.Fprintf(w, "        %#v: {%d, %d, %d, %d, %#v, %d, %#v},\n", name, e.ts, e.offset, e.size, e.usize, e.algo, e.crc, e.sum)
 	}
+	fmt.Fprintln(w, "    },")
 
+	fmt.Fprintf(w, "    dirs: map[string][]%sFI{\n", prefix)
+	var dirKeys []string
+	for d := range dirs {
+		dirKeys = append(dirKeys, d)
+	}
+	sort.Strings(dirKeys)
+	for _, d := range dirKeys {
+		fmt.Fprintf(w, "        %#v: {\n", d)
+		for _, c := range dirs[d] {
+			if c.isDir {
+				fmt.Fprintf(w, "            {name: %#v, mode: os.ModeDir | os.ModePerm},\n", c.name)
+			} else {
+				fe := filemap[c.full]
+				fmt.Fprintf(w, "            {name: %#v, size: %d, mode: os.ModePerm, ftime: time.Unix(%d, 0), sum: %#v},\n", c.name, fe.usize, fe.ts, fe.sum)
+			}
+		}
+		fmt.Fprintln(w, "        },")
+	}
 	fmt.Fprintln(w, "    },")
+
 	fmt.Fprintf(w, "    data: [%d]byte{\n", offset)
 	databuf.WriteTo(w)
 	fmt.Fprintln(w, "    },")
 	fmt.Fprintln(w, "}")
 
-	if w, ok := w.(io.Closer); ok {
-		w.Close()
+	src, err := formatSource(w.Bytes(), useGoimports)
+	if err != nil {
+		return fmt.Errorf("Failed to format generated source: %v", err)
+	}
+
+	dst, err := openDst()
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(src); err != nil {
+		return err
+	}
+	if c, ok := dst.(io.Closer); ok {
+		c.Close()
+	}
+
+	if manifestPath != "" {
+		if err := writeManifest(manifestPath, params, newManifest); err != nil {
+			return fmt.Errorf("Failed to write manifest: %v", err)
+		}
 	}
 	return nil
 }
 
+// errUpToDate is returned by generate when -manifest is set and every input,
+// along with the generation parameters that shape the output, still matches
+// what the manifest recorded last time, so there's nothing new to write.
+var errUpToDate = errors.New("manifest unchanged, nothing to do")
+
+// manifestEntry records what embedfiles saw for a single input file the last
+// time -manifest was used, so a later run can tell nothing has changed and
+// skip regenerating its output.
+type manifestEntry struct {
+	Path    string `json:"path"`
+	SHA256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+// genParams is the subset of generate's flags that shape the output for a
+// fixed set of inputs; it's fingerprinted into the manifest so that a flag
+// change (e.g. -compress, -package) is never mistaken for "nothing changed"
+// just because the input files themselves are unmodified.
+type genParams struct {
+	Pkg         string
+	Prefix      string
+	Compress    string
+	Tag         string
+	IncludeHTTP bool
+	Goimports   bool
+}
+
+func (p genParams) fingerprint() string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%+v", p)))
+	return hex.EncodeToString(sum[:])
+}
+
+// manifestFile is the on-disk JSON layout for -manifest: the generation
+// parameters' fingerprint alongside the per-input records, so either one
+// changing is enough to invalidate "up to date".
+type manifestFile struct {
+	Params string          `json:"params"`
+	Files  []manifestEntry `json:"files"`
+}
+
+// loadManifest reads the manifest at path, returning a zero-value params
+// fingerprint and a nil map (not an error) if it doesn't exist yet.
+func loadManifest(path string) (params string, files map[string]manifestEntry, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	var mf manifestFile
+	if err := json.Unmarshal(data, &mf); err != nil {
+		return "", nil, err
+	}
+	m := make(map[string]manifestEntry, len(mf.Files))
+	for _, e := range mf.Files {
+		m[e.Path] = e
+	}
+	return mf.Params, m, nil
+}
+
+// writeManifest writes params and entries to path as JSON, with entries
+// sorted by path for a stable, reviewable diff.
+func writeManifest(path, params string, entries map[string]manifestEntry) error {
+	list := make([]manifestEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	data, err := json.MarshalIndent(manifestFile{Params: params, Files: list}, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	return os.WriteFile(path, data, 0644)
+}
+
+// formatSource runs gofmt (and, if useGoimports is set, import fixing) over
+// generated source so successive runs over identical inputs produce
+// byte-identical, always-valid output.
+func formatSource(src []byte, useGoimports bool) ([]byte, error) {
+	if useGoimports {
+		return imports.Process("", src, nil)
+	}
+	return format.Source(src)
+}
+
+// generateDummy writes the !tag-gated stub that serves dummyPath from disk
+// at runtime via os.DirFS, exposing the same fs.FS-based API as generate's
+// output so the two are interchangeable depending on which build tag is set.
+func generateDummy(dst io.Writer, pkg, prefix, tag, dummyPath string, useGoimports bool) error {
+	w := new(bytes.Buffer)
+	err := dummyTmpl.Execute(w, map[string]interface{}{
+		"IncludeHTTP": *incHTTP,
+		"Pkg":         pkg,
+		"Prefix":      prefix,
+		"Tag":         tag,
+		"DummyPathQ":  fmt.Sprintf("%q", dummyPath),
+	})
+	if err != nil {
+		return err
+	}
+	src, err := formatSource(w.Bytes(), useGoimports)
+	if err != nil {
+		return fmt.Errorf("Failed to format generated dummy source: %v", err)
+	}
+	if _, err := dst.Write(src); err != nil {
+		return err
+	}
+	if c, ok := dst.(io.Closer); ok {
+		c.Close()
+	}
+	return nil
+}
+
+// dummyFilename derives the path for the !tag-gated stub from the path of
+// the main generated file, e.g. "assets.go" -> "assets_dummy.go".
+func dummyFilename(fn string) string {
+	ext := filepath.Ext(fn)
+	return strings.TrimSuffix(fn, ext) + "_dummy" + ext
+}
+
 func main() {
-	var out io.Writer = os.Stdout
+	flag.Parse()
 
 	if flag.NArg() == 0 {
 		fail("No globs specified")
 	}
 
-	if *fn != "" && *fn != "-" {
-		f, err := os.Create(*fn)
-		if err != nil {
-			fail("Failed to open file for write: %v", err)
+	switch *compress {
+	case "none", "gzip", "brotli", "auto":
+	default:
+		fail("Invalid -compress value %q: must be none, gzip, brotli or auto", *compress)
+	}
+
+	if *tag != "" {
+		if *dummyPath == "" {
+			fail("-dummy is required when -tag is set")
+		}
+		if *fn == "" || *fn == "-" {
+			fail("-filename is required when -tag is set")
 		}
-		out = f
 	}
 
-	if err := generate(out, *pkg, *prefix, flag.Args()); err != nil {
+	openOut := func() (io.Writer, error) {
+		if *fn == "" || *fn == "-" {
+			return os.Stdout, nil
+		}
+		return os.Create(*fn)
+	}
+
+	opts := walkOpts{
+		recursive:   *recursive,
+		root:        *root,
+		stripPrefix: *stripPrefix,
+		includes:    includes,
+		excludes:    excludes,
+	}
+	if err := generate(openOut, *pkg, *prefix, *compress, *tag, flag.Args(), opts, *goimportsFl, *manifestFl); err != nil {
+		if errors.Is(err, errUpToDate) {
+			fmt.Fprintln(os.Stderr, "embedfiles:", err)
+			return
+		}
 		fail(err.Error())
 	}
+
+	if *tag != "" {
+		df, err := os.Create(dummyFilename(*fn))
+		if err != nil {
+			fail("Failed to open dummy file for write: %v", err)
+		}
+		if err := generateDummy(df, *pkg, *prefix, *tag, *dummyPath, *goimportsFl); err != nil {
+			fail(err.Error())
+		}
+	}
 }
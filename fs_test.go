@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildDirs(t *testing.T) {
+	dirs := buildDirs([]string{"a.txt", "sub/b.txt", "sub/c.txt"})
+
+	root := dirs["."]
+	if len(root) != 2 {
+		t.Fatalf("dirs[\".\"] = %+v, want 2 entries", root)
+	}
+	if root[0].name != "a.txt" || root[0].isDir {
+		t.Errorf("dirs[\".\"][0] = %+v, want the file a.txt", root[0])
+	}
+	if root[1].name != "sub" || !root[1].isDir {
+		t.Errorf("dirs[\".\"][1] = %+v, want the directory sub", root[1])
+	}
+
+	sub := dirs["sub"]
+	if len(sub) != 2 || sub[0].full != "sub/b.txt" || sub[1].full != "sub/c.txt" {
+		t.Errorf("dirs[\"sub\"] = %+v, want b.txt then c.txt", sub)
+	}
+}
+
+// TestGeneratedFSConformance generates an asset bundle for a small file
+// tree, compiles it into a throwaway module and runs testing/fstest.TestFS
+// against the result along with a couple of invalid-path checks -- the same
+// way the stdlib recommends validating an fs.FS implementation. This pins
+// down the fs.ValidPath enforcement in the generated Open/Stat/ReadDir/Sub
+// methods.
+func TestGeneratedFSConformance(t *testing.T) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	assetsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(assetsDir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(assetsDir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(assetsDir, "sub", "b.txt"), []byte("world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	opts := walkOpts{recursive: true, root: assetsDir}
+	if err := generate(func() (io.Writer, error) { return &buf, nil },
+		"main", "Assets", "none", "", []string{assetsDir}, opts, false, ""); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	modDir := t.TempDir()
+	writeFile(t, filepath.Join(modDir, "go.mod"), "module gentest\n\ngo 1.21.6\n")
+	writeFile(t, filepath.Join(modDir, "assets_gen.go"), buf.String())
+	writeFile(t, filepath.Join(modDir, "main.go"), fsConformanceDriver)
+
+	cmd := exec.Command(goBin, "run", ".")
+	cmd.Dir = modDir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go run failed: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "PASS") {
+		t.Fatalf("generated fs.FS did not pass conformance checks:\n%s", out)
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const fsConformanceDriver = `package main
+
+import (
+	"fmt"
+	"testing/fstest"
+)
+
+func main() {
+	if err := fstest.TestFS(Assets, "a.txt", "sub/b.txt"); err != nil {
+		fmt.Println("FAIL fstest.TestFS:", err)
+		return
+	}
+	if _, err := Assets.Open("/a.txt"); err == nil {
+		fmt.Println("FAIL: Open(/a.txt) succeeded, want error")
+		return
+	}
+	if _, err := Assets.Stat("../a.txt"); err == nil {
+		fmt.Println("FAIL: Stat(../a.txt) succeeded, want error")
+		return
+	}
+	fmt.Println("PASS")
+}
+`
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+
+	params, entries, err := loadManifest(path)
+	if err != nil {
+		t.Fatalf("loadManifest on a missing file: %v", err)
+	}
+	if params != "" || entries != nil {
+		t.Fatalf("loadManifest on a missing file = (%q, %v), want (\"\", nil)", params, entries)
+	}
+
+	want := map[string]manifestEntry{
+		"a.txt": {Path: "a.txt", SHA256: "abc", Size: 3, ModTime: 100},
+	}
+	if err := writeManifest(path, "fp1", want); err != nil {
+		t.Fatal(err)
+	}
+
+	gotParams, gotEntries, err := loadManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotParams != "fp1" {
+		t.Errorf("loadManifest params = %q, want %q", gotParams, "fp1")
+	}
+	if len(gotEntries) != 1 || gotEntries["a.txt"] != want["a.txt"] {
+		t.Errorf("loadManifest entries = %+v, want %+v", gotEntries, want)
+	}
+}
+
+func TestGenParamsFingerprint(t *testing.T) {
+	p1 := genParams{Pkg: "main", Prefix: "Assets", Compress: "none"}
+	p2 := p1
+	p2.Compress = "gzip"
+
+	if p1.fingerprint() != (genParams{Pkg: "main", Prefix: "Assets", Compress: "none"}).fingerprint() {
+		t.Error("fingerprint is not stable across equal values")
+	}
+	if p1.fingerprint() == p2.fingerprint() {
+		t.Error("changing Compress did not change the fingerprint")
+	}
+}
+
+// TestGenerateManifestIncrementalSkip reproduces the reviewer's repro case:
+// generating twice with unchanged inputs but a different -compress setting
+// must not be mistaken for "nothing to do".
+func TestGenerateManifestIncrementalSkip(t *testing.T) {
+	assetsDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(assetsDir, "a.txt"), "hello")
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	opts := walkOpts{recursive: true, root: assetsDir}
+
+	var buf bytes.Buffer
+	err := generate(func() (io.Writer, error) { return &buf, nil },
+		"main", "Assets", "none", "", []string{assetsDir}, opts, false, manifestPath)
+	if err != nil {
+		t.Fatalf("first generate: %v", err)
+	}
+
+	// Same inputs, same flags: the manifest should report nothing to do.
+	buf.Reset()
+	err = generate(func() (io.Writer, error) { return &buf, nil },
+		"main", "Assets", "none", "", []string{assetsDir}, opts, false, manifestPath)
+	if err != errUpToDate {
+		t.Fatalf("rerun with unchanged inputs and flags = %v, want errUpToDate", err)
+	}
+
+	// Same inputs, different -compress: must regenerate, not skip.
+	buf.Reset()
+	err = generate(func() (io.Writer, error) { return &buf, nil },
+		"main", "Assets", "gzip", "", []string{assetsDir}, opts, false, manifestPath)
+	if err != nil {
+		t.Fatalf("rerun with a changed flag: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("rerun with a changed flag produced no output")
+	}
+	if !strings.Contains(buf.String(), `"gzip"`) {
+		t.Errorf("regenerated output doesn't reflect -compress gzip:\n%s", buf.String())
+	}
+}
+
+// TestGenerateDedupesIdenticalContent checks that two inputs with identical
+// content are stored once: the generated file map points both names at the
+// same offset/size/sum instead of appending the bytes twice.
+func TestGenerateDedupesIdenticalContent(t *testing.T) {
+	assetsDir := t.TempDir()
+	mustWriteFile(t, filepath.Join(assetsDir, "a.txt"), "same content")
+	mustWriteFile(t, filepath.Join(assetsDir, "b.txt"), "same content")
+	opts := walkOpts{recursive: true, root: assetsDir}
+
+	var buf bytes.Buffer
+	err := generate(func() (io.Writer, error) { return &buf, nil },
+		"main", "Assets", "none", "", []string{assetsDir}, opts, false, "")
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	src := buf.String()
+
+	aIdx := strings.Index(src, `"a.txt":`)
+	bIdx := strings.Index(src, `"b.txt":`)
+	if aIdx < 0 || bIdx < 0 {
+		t.Fatalf("generated source missing file entries:\n%s", src)
+	}
+	aLine := src[aIdx : aIdx+strings.IndexByte(src[aIdx:], '\n')]
+	bLine := src[bIdx : bIdx+strings.IndexByte(src[bIdx:], '\n')]
+	aFields := aLine[strings.IndexByte(aLine, '{'):]
+	bFields := bLine[strings.IndexByte(bLine, '{'):]
+	if aFields != bFields {
+		t.Errorf("identical-content files were stored with different entries:\na.txt: %s\nb.txt: %s", aFields, bFields)
+	}
+
+	if strings.Count(src, "// a.txt") != 1 {
+		t.Errorf("expected a.txt's data comment once, found it %d times", strings.Count(src, "// a.txt"))
+	}
+	if strings.Contains(src, "// b.txt") {
+		t.Error("b.txt's content was re-appended to the data buffer instead of reusing a.txt's bytes")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlobPattern(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*.txt", "a.txt", true},
+		{"*.txt", "sub/a.txt", true},
+		{"*.txt", "a.json", false},
+		{"sub/*.txt", "sub/a.txt", true},
+		{"sub/*.txt", "other/a.txt", false},
+		{"**/*.txt", "a.txt", true},
+		{"**/*.txt", "a/b/c.txt", true},
+		{"a/**/z.txt", "a/z.txt", true},
+		{"a/**/z.txt", "a/b/c/z.txt", true},
+		{"a/**/z.txt", "a/b/c/y.txt", false},
+	}
+	for _, c := range cases {
+		got, err := matchGlobPattern(c.pattern, c.path)
+		if err != nil {
+			t.Errorf("matchGlobPattern(%q, %q): %v", c.pattern, c.path, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("matchGlobPattern(%q, %q) = %v, want %v", c.pattern, c.path, got, c.want)
+		}
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	patterns := []string{"*.md", "vendor/**"}
+	if ok, _ := matchAny(patterns, "README.md"); !ok {
+		t.Error("expected README.md to match *.md")
+	}
+	if ok, _ := matchAny(patterns, "vendor/a/b.go"); !ok {
+		t.Error("expected vendor/a/b.go to match vendor/**")
+	}
+	if ok, _ := matchAny(patterns, "main.go"); ok {
+		t.Error("expected main.go not to match")
+	}
+}
+
+func TestRelativeName(t *testing.T) {
+	name, err := relativeName("/assets/sub/a.txt", "/assets", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "sub/a.txt" {
+		t.Errorf("relativeName = %q, want %q", name, "sub/a.txt")
+	}
+
+	name, err = relativeName("/assets/static/sub/a.txt", "/assets", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "sub/a.txt" {
+		t.Errorf("relativeName with strip-prefix = %q, want %q", name, "sub/a.txt")
+	}
+}
+
+func TestWalkDirSymlinkCycle(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, filepath.Join(root, "sub", "loop")); err != nil {
+		t.Skipf("symlinks not supported in this environment: %v", err)
+	}
+
+	err := walkDir(root, func(path string) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "symlink cycle") {
+		t.Fatalf("walkDir on a self-referencing tree = %v, want a symlink cycle error", err)
+	}
+}
+
+func TestWalkInputsRecursiveExclude(t *testing.T) {
+	root := t.TempDir()
+	mustWriteFile(t, filepath.Join(root, "a.txt"), "a")
+	mustWriteFile(t, filepath.Join(root, "a.md"), "a-doc")
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mustWriteFile(t, filepath.Join(root, "sub", "b.txt"), "b")
+
+	opts := walkOpts{recursive: true, root: root, excludes: []string{"*.md"}}
+	inputs, err := walkInputs([]string{root}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, in := range inputs {
+		names = append(names, in.name)
+	}
+	sort.Strings(names)
+	want := []string{"a.txt", "sub/b.txt"}
+	if len(names) != len(want) {
+		t.Fatalf("walkInputs names = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("walkInputs names = %v, want %v", names, want)
+		}
+	}
+}
+
+func mustWriteFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}